@@ -0,0 +1,438 @@
+package tftp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// Client is a TFTP client implementing the same lockstep state machine
+// (with RFC 2347/2348/2349/7440 option negotiation) as TFTPServer.
+type Client struct {
+	Retries uint8
+	Timeout time.Duration
+	// BlockSize, if non-zero, requests the blksize option (RFC 2348).
+	BlockSize int
+	// WindowSize, if greater than 1, requests the windowsize option (RFC 7440).
+	WindowSize uint16
+	// RequestTSize, if true, requests the tsize option (RFC 2349).
+	RequestTSize bool
+}
+
+func (c *Client) retries() uint8 {
+	if c.Retries == 0 {
+		return 10
+	}
+	return c.Retries
+}
+
+func (c *Client) timeout() time.Duration {
+	if c.Timeout == 0 {
+		return 4 * time.Second
+	}
+	return c.Timeout
+}
+
+func (c *Client) requestOptions() map[string]string {
+	opts := make(map[string]string)
+
+	if c.BlockSize > 0 {
+		opts["blksize"] = strconv.Itoa(c.BlockSize)
+	}
+	if c.WindowSize > 1 {
+		opts["windowsize"] = strconv.Itoa(int(c.WindowSize))
+	}
+	if c.RequestTSize {
+		opts["tsize"] = "0"
+	}
+
+	if len(opts) == 0 {
+		return nil
+	}
+	return opts
+}
+
+// watchContext forces conn's pending deadline into the past as soon as ctx
+// is cancelled, so a blocked Read/ReadFrom returns promptly instead of
+// waiting out the full retry timeout.
+func watchContext(ctx context.Context, conn net.PacketConn) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = conn.SetDeadline(time.Now())
+		case <-done:
+		}
+	}()
+	return func() { close(done) }
+}
+
+// exchange sends payload to "to" and waits for any reply, retrying on
+// timeout. The address the reply came from is reported via *tid - used to
+// lock onto the server's ephemeral TID on the very first reply.
+func (c *Client) exchange(ctx context.Context, conn net.PacketConn, to net.Addr, tid *net.Addr, payload, buf []byte) ([]byte, error) {
+	for i := c.retries(); i > 0; i-- {
+		if _, err := conn.WriteTo(payload, to); err != nil {
+			return nil, err
+		}
+
+		_ = conn.SetReadDeadline(time.Now().Add(c.timeout()))
+
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			if nErr, ok := err.(net.Error); ok && nErr.Timeout() {
+				continue
+			}
+			return nil, err
+		}
+
+		*tid = addr
+		return buf[:n], nil
+	}
+
+	return nil, errors.New("tftp: exhausted retries")
+}
+
+// sendAndAwait is like exchange, but the server's TID is already locked in
+// and replies from any other address are ignored rather than accepted.
+func (c *Client) sendAndAwait(ctx context.Context, conn net.PacketConn, to net.Addr, payload, buf []byte) ([]byte, error) {
+	for i := c.retries(); i > 0; i-- {
+		if _, err := conn.WriteTo(payload, to); err != nil {
+			return nil, err
+		}
+
+		_ = conn.SetReadDeadline(time.Now().Add(c.timeout()))
+
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			if nErr, ok := err.(net.Error); ok && nErr.Timeout() {
+				continue
+			}
+			return nil, err
+		}
+
+		if addr.String() != to.String() {
+			continue
+		}
+
+		return buf[:n], nil
+	}
+
+	return nil, errors.New("tftp: exhausted retries")
+}
+
+// readFrom waits for the next packet from the locked TID without
+// retransmitting anything, for use inside an already-open RFC 7440 window.
+func (c *Client) readFrom(ctx context.Context, conn net.PacketConn, from net.Addr, buf []byte) ([]byte, error) {
+	for i := c.retries(); i > 0; i-- {
+		_ = conn.SetReadDeadline(time.Now().Add(c.timeout()))
+
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			if nErr, ok := err.(net.Error); ok && nErr.Timeout() {
+				continue
+			}
+			return nil, err
+		}
+
+		if addr.String() != from.String() {
+			continue
+		}
+
+		return buf[:n], nil
+	}
+
+	return nil, errors.New("tftp: exhausted retries")
+}
+
+// Get downloads filename from remote, writing its contents to w.
+func (c *Client) Get(ctx context.Context, remote, filename string, w io.Writer) error {
+	raddr, err := net.ResolveUDPAddr("udp", remote)
+	if err != nil {
+		return err
+	}
+
+	conn, err := net.ListenPacket("udp", ":0")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = conn.Close() }()
+
+	stop := watchContext(ctx, conn)
+	defer stop()
+
+	reqData, err := ReadReq{Filename: filename, Mode: "octet", Options: c.requestOptions()}.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	buf := make([]byte, maxBlockSize+4)
+
+	var serverTID net.Addr
+	reply, err := c.exchange(ctx, conn, raddr, &serverTID, reqData, buf)
+	if err != nil {
+		return err
+	}
+
+	blockSize := BlockSize
+	var windowSize uint16 = 1
+
+	var oackPkt OAck
+	if oackPkt.UnmarshalBinary(reply) == nil {
+		if v, ok := oackPkt.Options["blksize"]; ok {
+			if n, err := strconv.Atoi(v); err == nil {
+				blockSize = n
+			}
+		}
+		if v, ok := oackPkt.Options["windowsize"]; ok {
+			if n, err := strconv.Atoi(v); err == nil {
+				windowSize = uint16(n)
+			}
+		}
+
+		ackData, err := Ack{Block: 0}.MarshalBinary()
+		if err != nil {
+			return err
+		}
+
+		reply, err = c.sendAndAwait(ctx, conn, serverTID, ackData, buf)
+		if err != nil {
+			return err
+		}
+	}
+
+	dataPkt := Data{BlockSize: blockSize}
+	var errPkt Err
+	var ackPkt Ack
+	var received uint16
+	var expectedBlock uint16 = 1
+
+	for {
+		if dataPkt.UnmarshalBinary(reply) != nil {
+			if errPkt.UnmarshalBinary(reply) == nil {
+				return fmt.Errorf("tftp: server error: %s", errPkt.Message)
+			}
+			return errors.New("tftp: bad DATA packet")
+		}
+
+		payload, err := io.ReadAll(dataPkt.Payload)
+		if err != nil {
+			return err
+		}
+
+		// A retransmitted DATA packet (the sender's retry after a delayed
+		// ACK, or a resent window after a lost block) repeats a block
+		// we've already written, or arrives out of order while an earlier
+		// block is still missing; skip the write and wait for the block
+		// we actually need next. done only becomes true once the block we
+		// were expecting turns out to be short - never on an out-of-order
+		// packet that merely happens to be short.
+		done := false
+		if dataPkt.Block == expectedBlock {
+			if _, err := w.Write(payload); err != nil {
+				return err
+			}
+			expectedBlock++
+			done = len(payload) < blockSize
+		}
+
+		received++
+
+		if !done && received < windowSize {
+			reply, err = c.readFrom(ctx, conn, serverTID, buf)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		// The cumulative ACK reports the highest block actually written in
+		// order, not whichever packet happened to complete the window
+		// count - otherwise a lost block can make the ACK coincidentally
+		// equal the window end and the sender wrongly believes everything
+		// landed.
+		ackPkt.Block = expectedBlock - 1
+		ackData, err := ackPkt.MarshalBinary()
+		if err != nil {
+			return err
+		}
+
+		if done {
+			// best-effort final ACK; the transfer is already complete.
+			_, _ = conn.WriteTo(ackData, serverTID)
+			return nil
+		}
+
+		reply, err = c.sendAndAwait(ctx, conn, serverTID, ackData, buf)
+		if err != nil {
+			return err
+		}
+		received = 0
+	}
+}
+
+// Put uploads the contents of r to remote under filename.
+func (c *Client) Put(ctx context.Context, remote, filename string, r io.Reader) error {
+	raddr, err := net.ResolveUDPAddr("udp", remote)
+	if err != nil {
+		return err
+	}
+
+	conn, err := net.ListenPacket("udp", ":0")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = conn.Close() }()
+
+	stop := watchContext(ctx, conn)
+	defer stop()
+
+	reqData, err := WriteReq{Filename: filename, Mode: "octet", Options: c.requestOptions()}.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	buf := make([]byte, maxBlockSize+4)
+
+	var serverTID net.Addr
+	reply, err := c.exchange(ctx, conn, raddr, &serverTID, reqData, buf)
+	if err != nil {
+		return err
+	}
+
+	blockSize := BlockSize
+	var windowSize uint16 = 1
+
+	var oackPkt OAck
+	var ackPkt Ack
+	var errPkt Err
+
+	switch {
+	case oackPkt.UnmarshalBinary(reply) == nil:
+		if v, ok := oackPkt.Options["blksize"]; ok {
+			if n, err := strconv.Atoi(v); err == nil {
+				blockSize = n
+			}
+		}
+		if v, ok := oackPkt.Options["windowsize"]; ok {
+			if n, err := strconv.Atoi(v); err == nil {
+				windowSize = uint16(n)
+			}
+		}
+
+		// ACK block 0 confirms the negotiation before DATA starts flowing.
+		ackData, err := (Ack{Block: 0}).MarshalBinary()
+		if err != nil {
+			return err
+		}
+		if _, err := conn.WriteTo(ackData, serverTID); err != nil {
+			return err
+		}
+	case ackPkt.UnmarshalBinary(reply) == nil && ackPkt.Block == 0:
+		// plain RFC 1350 ACK; no options were negotiated.
+	case errPkt.UnmarshalBinary(reply) == nil:
+		return fmt.Errorf("tftp: server error: %s", errPkt.Message)
+	default:
+		return errors.New("tftp: unexpected reply to WRQ")
+	}
+
+	var block uint16
+	eof := false
+
+NEXTWINDOW:
+	for !eof {
+		window := make([][]byte, 0, windowSize)
+		lastLen := blockSize
+
+		for uint16(len(window)) < windowSize && !eof {
+			chunk := make([]byte, blockSize)
+			n, rerr := io.ReadFull(r, chunk)
+			if rerr == io.ErrUnexpectedEOF || rerr == io.EOF {
+				eof = true
+			} else if rerr != nil {
+				return rerr
+			}
+			chunk = chunk[:n]
+			lastLen = n
+
+			block++
+			d := Data{Block: block - 1, BlockSize: blockSize, Payload: bytes.NewReader(chunk)}
+			pkt, err := d.MarshalBinary()
+			if err != nil {
+				return err
+			}
+			window = append(window, pkt)
+		}
+
+		if len(window) == 0 {
+			break
+		}
+
+		// A transfer that ends exactly on a block boundary still needs a
+		// trailing empty DATA packet to signal EOF.
+		if eof && lastLen == blockSize {
+			block++
+			d := Data{Block: block - 1, BlockSize: blockSize, Payload: bytes.NewReader(nil)}
+			pkt, err := d.MarshalBinary()
+			if err != nil {
+				return err
+			}
+			window = append(window, pkt)
+		}
+
+		lastBlock := block
+
+	RETRY:
+		for i := c.retries(); i > 0; i-- {
+			for _, pkt := range window {
+				if _, err := conn.WriteTo(pkt, serverTID); err != nil {
+					return err
+				}
+			}
+
+			_ = conn.SetReadDeadline(time.Now().Add(c.timeout()))
+
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				if nErr, ok := err.(net.Error); ok && nErr.Timeout() {
+					continue RETRY
+				}
+				return err
+			}
+
+			if addr.String() != serverTID.String() {
+				continue RETRY
+			}
+
+			switch {
+			case ackPkt.UnmarshalBinary(buf[:n]) == nil:
+				if ackPkt.Block == lastBlock {
+					continue NEXTWINDOW
+				}
+			case errPkt.UnmarshalBinary(buf[:n]) == nil:
+				return fmt.Errorf("tftp: server error: %s", errPkt.Message)
+			}
+		}
+
+		return errors.New("tftp: exhausted retries")
+	}
+
+	return nil
+}
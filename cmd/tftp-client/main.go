@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+
+	"github.com/Layer8Collective/tftp"
+)
+
+var (
+	remote     = flag.String("r", "127.0.0.1:69", "TFTP Server Address")
+	filename   = flag.String("f", "gopher.png", "Remote File to Get/Put")
+	output     = flag.String("o", "", "Local file path; defaults to the remote filename")
+	put        = flag.Bool("put", false, "Upload the local file instead of downloading")
+	blockSize  = flag.Int("blksize", 0, "Requested blksize option, 0 to not negotiate")
+	windowSize = flag.Int("windowsize", 0, "Requested windowsize option, 0 to not negotiate")
+	tsize      = flag.Bool("tsize", false, "Request the tsize option")
+)
+
+func main() {
+	flag.Parse()
+
+	localPath := *output
+	if localPath == "" {
+		localPath = *filename
+	}
+
+	c := &tftp.Client{
+		BlockSize:    *blockSize,
+		WindowSize:   uint16(*windowSize),
+		RequestTSize: *tsize,
+	}
+
+	if *put {
+		f, err := os.Open(localPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer func() { _ = f.Close() }()
+
+		if err := c.Put(context.Background(), *remote, *filename, f); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	f, err := os.Create(localPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer func() { _ = f.Close() }()
+
+	if err := c.Get(context.Background(), *remote, *filename, f); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,71 @@
+package tftp
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestConcurrentSessions runs two RRQ transfers against the same server at
+// the same time and confirms each session's ephemeral TID keeps it talking
+// only to its own client - neither transfer should observe the other's data.
+func TestConcurrentSessions(t *testing.T) {
+	dir := t.TempDir()
+
+	fileA := bytes.Repeat([]byte("A"), 50_000)
+	fileB := bytes.Repeat([]byte("B"), 50_000)
+
+	if err := os.WriteFile(filepath.Join(dir, "a.bin"), fileA, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "b.bin"), fileB, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	server := TFTPServer{Filesystem: OSFilesystem{Root: dir}}
+	go func() { _ = server.Serve(conn) }()
+
+	addr := conn.LocalAddr().String()
+
+	get := func(filename string, want []byte) error {
+		var out bytes.Buffer
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		client := Client{}
+		if err := client.Get(ctx, addr, filename, &out); err != nil {
+			return err
+		}
+		if !bytes.Equal(out.Bytes(), want) {
+			return fmt.Errorf("%s: downloaded content did not match (got %d bytes, want %d)", filename, out.Len(), len(want))
+		}
+		return nil
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 2)
+
+	wg.Add(2)
+	go func() { defer wg.Done(); errs <- get("a.bin", fileA) }()
+	go func() { defer wg.Done(); errs <- get("b.bin", fileB) }()
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+}
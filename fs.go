@@ -0,0 +1,163 @@
+package tftp
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Filesystem is the storage backend a TFTPServer serves RRQ/WRQ sessions
+// against. It mirrors the io/fs "filesystem as an interface" pattern so the
+// protocol layer never has to know whether files live on disk, in memory,
+// or in an embed.FS.
+type Filesystem interface {
+	Open(name string) (io.ReadSeekCloser, error)
+	Create(name string) (io.WriteCloser, error)
+	Stat(name string) (fs.FileInfo, error)
+}
+
+// readSeekNopCloser adapts an io.ReadSeeker (e.g. a bytes.Reader over an
+// in-memory payload) to io.ReadSeekCloser with a no-op Close.
+type readSeekNopCloser struct {
+	*bytes.Reader
+}
+
+func (readSeekNopCloser) Close() error { return nil }
+
+// OSFilesystem serves files rooted at Root on the local disk. Filenames are
+// resolved relative to Root; absolute paths, ".." traversal, and symlinks
+// that escape Root are all rejected.
+type OSFilesystem struct {
+	Root string
+}
+
+func (o OSFilesystem) resolve(name string, forCreate bool) (string, error) {
+	if name == "" {
+		return "", errors.New("tftp: empty filename")
+	}
+
+	if filepath.IsAbs(name) {
+		return "", errors.New("tftp: absolute paths are not allowed")
+	}
+
+	clean := filepath.Clean(name)
+	if clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return "", errors.New("tftp: path traversal is not allowed")
+	}
+
+	root, err := filepath.Abs(o.Root)
+	if err != nil {
+		return "", err
+	}
+
+	full := filepath.Join(root, clean)
+
+	if err := checkNoSymlinkEscape(root, filepath.Dir(full)); err != nil {
+		return "", err
+	}
+
+	if err := checkNoSymlinkEscape(root, full); err != nil {
+		return "", err
+	}
+
+	if forCreate {
+		// os.Create follows a dangling symlink at the final path and creates
+		// its target, wherever it points, so such a link is rejected outright.
+		if fi, err := os.Lstat(full); err == nil && fi.Mode()&fs.ModeSymlink != 0 {
+			if _, err := filepath.EvalSymlinks(full); err != nil {
+				return "", errors.New("tftp: path escapes root via symlink")
+			}
+		}
+	}
+
+	return full, nil
+}
+
+// checkNoSymlinkEscape verifies that path, once symlinks are resolved,
+// still lives under root. Paths that don't exist yet (e.g. the file a WRQ
+// is about to create) are allowed through unresolved.
+func checkNoSymlinkEscape(root, path string) error {
+	resolvedPath, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		// Doesn't exist (yet) - nothing to escape through.
+		return nil
+	}
+
+	resolvedRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return err
+	}
+
+	rel, err := filepath.Rel(resolvedRoot, resolvedPath)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return errors.New("tftp: path escapes root via symlink")
+	}
+
+	return nil
+}
+
+func (o OSFilesystem) Open(name string) (io.ReadSeekCloser, error) {
+	full, err := o.resolve(name, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return os.Open(full)
+}
+
+func (o OSFilesystem) Create(name string) (io.WriteCloser, error) {
+	full, err := o.resolve(name, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return os.Create(full)
+}
+
+func (o OSFilesystem) Stat(name string) (fs.FileInfo, error) {
+	full, err := o.resolve(name, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return os.Stat(full)
+}
+
+// ReadOnlyFS adapts a standard library fs.FS (embed.FS, os.DirFS, ...) into
+// a Filesystem that only ever answers RRQs; any WRQ is rejected.
+type ReadOnlyFS struct {
+	FS fs.FS
+}
+
+func (r ReadOnlyFS) Open(name string) (io.ReadSeekCloser, error) {
+	f, err := r.FS.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if rsc, ok := f.(io.ReadSeekCloser); ok {
+		return rsc, nil
+	}
+
+	// fs.File doesn't guarantee Seek support; buffer it so the windowed
+	// reader can still address arbitrary blocks.
+	data, err := io.ReadAll(f)
+	_ = f.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return readSeekNopCloser{bytes.NewReader(data)}, nil
+}
+
+func (r ReadOnlyFS) Create(name string) (io.WriteCloser, error) {
+	return nil, errors.New("tftp: read-only filesystem")
+}
+
+func (r ReadOnlyFS) Stat(name string) (fs.FileInfo, error) {
+	return fs.Stat(r.FS, name)
+}
@@ -0,0 +1,61 @@
+package tftp
+
+import (
+	"log"
+	"net"
+)
+
+// Logger is the logging sink a TFTPServer writes diagnostic messages to. It
+// is satisfied by *log.Logger, so passing one in requires no adapter code.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// Direction describes which way a packet travelled relative to the server,
+// for use with TFTPServer.OnPacket.
+type Direction int
+
+const (
+	DirSent Direction = iota
+	DirReceived
+)
+
+func (d Direction) String() string {
+	if d == DirSent {
+		return "sent"
+	}
+	return "received"
+}
+
+// logf writes to s.Logger when set, or the standard library's default
+// logger otherwise.
+func (s TFTPServer) logf(format string, v ...interface{}) {
+	if s.Logger != nil {
+		s.Logger.Printf(format, v...)
+		return
+	}
+	log.Printf(format, v...)
+}
+
+// fireSessionStart invokes OnSessionStart, if set. req is either a ReadReq
+// or a WriteReq.
+func (s TFTPServer) fireSessionStart(remote net.Addr, req interface{}) {
+	if s.OnSessionStart != nil {
+		s.OnSessionStart(remote, req)
+	}
+}
+
+// fireSessionEnd invokes OnSessionEnd, if set, reporting the number of
+// payload bytes transferred and the error (if any) the session ended with.
+func (s TFTPServer) fireSessionEnd(remote net.Addr, bytes int64, err error) {
+	if s.OnSessionEnd != nil {
+		s.OnSessionEnd(remote, bytes, err)
+	}
+}
+
+// firePacket invokes OnPacket, if set.
+func (s TFTPServer) firePacket(dir Direction, op OperationCode) {
+	if s.OnPacket != nil {
+		s.OnPacket(dir, op)
+	}
+}
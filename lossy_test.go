@@ -0,0 +1,118 @@
+package tftp
+
+import (
+	"encoding/binary"
+	"net"
+	"sync"
+)
+
+// lossyRelay forwards UDP datagrams between a single client and a real
+// TFTPServer, dropping chosen DATA packets in transit (in either
+// direction, so it covers both Client.Get's RRQ downloads and
+// Client.Put's WRQ uploads) so tests can exercise RFC 7440 windowed
+// retransmission under actual packet loss rather than loss injected at
+// the library level. Each session's server TID differs from the
+// server's well-known listening address (RFC 1350 §4), so the relay
+// learns it from the first reply and forwards subsequent client packets
+// there instead.
+type lossyRelay struct {
+	clientSide *net.UDPConn
+	serverSide *net.UDPConn
+
+	mu         sync.Mutex
+	serverAddr *net.UDPAddr
+	clientAddr *net.UDPAddr
+	drop       map[uint16]int
+}
+
+func newLossyRelay(serverAddr *net.UDPAddr, drop map[uint16]int) (*lossyRelay, error) {
+	clientSide, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		return nil, err
+	}
+
+	serverSide, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		_ = clientSide.Close()
+		return nil, err
+	}
+
+	return &lossyRelay{
+		clientSide: clientSide,
+		serverSide: serverSide,
+		serverAddr: serverAddr,
+		drop:       drop,
+	}, nil
+}
+
+func (r *lossyRelay) addr() string {
+	return r.clientSide.LocalAddr().String()
+}
+
+func (r *lossyRelay) close() {
+	_ = r.clientSide.Close()
+	_ = r.serverSide.Close()
+}
+
+// run pumps datagrams in both directions until either socket closes. It
+// blocks, so callers should invoke it in its own goroutine.
+func (r *lossyRelay) run() {
+	go func() {
+		buf := make([]byte, maxBlockSize+4)
+		for {
+			n, addr, err := r.clientSide.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+
+			r.mu.Lock()
+			r.clientAddr = addr
+			dropped := isDroppedData(buf[:n], r.drop)
+			to := r.serverAddr
+			r.mu.Unlock()
+
+			if dropped {
+				continue
+			}
+			if _, err := r.serverSide.WriteToUDP(buf[:n], to); err != nil {
+				return
+			}
+		}
+	}()
+
+	buf := make([]byte, maxBlockSize+4)
+	for {
+		n, from, err := r.serverSide.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+
+		r.mu.Lock()
+		r.serverAddr = from
+		dropped := isDroppedData(buf[:n], r.drop)
+		to := r.clientAddr
+		r.mu.Unlock()
+
+		if dropped || to == nil {
+			continue
+		}
+		if _, err := r.clientSide.WriteToUDP(buf[:n], to); err != nil {
+			return
+		}
+	}
+}
+
+// isDroppedData reports whether pkt is a DATA packet for a block listed in
+// drop, decrementing its remaining drop count as a side effect.
+func isDroppedData(pkt []byte, drop map[uint16]int) bool {
+	if len(pkt) < 4 || OperationCode(binary.BigEndian.Uint16(pkt[0:2])) != OpData {
+		return false
+	}
+
+	block := binary.BigEndian.Uint16(pkt[2:4])
+	if drop[block] <= 0 {
+		return false
+	}
+	drop[block]--
+	return true
+}
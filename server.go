@@ -4,12 +4,75 @@ import (
 	"bytes"
 	"errors"
 	"io"
-	"log"
 	"net"
-	"os"
+	"strconv"
 	"time"
 )
 
+const (
+	// RFC 2348 bounds on the negotiated blksize option.
+	minBlockSize = 8
+	maxBlockSize = 65464
+)
+
+// negotiateOptions inspects the RFC 2347 options sent with a RRQ/WRQ and
+// builds the OACK reply, the effective block size, read/write timeout and
+// window size (RFC 7440) to use for the rest of the session. Options it
+// doesn't understand are left out of the reply and otherwise ignored, per
+// RFC 2347. maxWindowSize is the largest window the server is willing to
+// use; a client request above it is clamped down.
+func negotiateOptions(opts map[string]string, timeout time.Duration, tsize int64, haveTSize bool, maxWindowSize uint16) (oack map[string]string, blockSize int, sessionTimeout time.Duration, windowSize uint16) {
+	blockSize = BlockSize
+	sessionTimeout = timeout
+	windowSize = 1
+
+	if len(opts) == 0 {
+		return nil, blockSize, sessionTimeout, windowSize
+	}
+
+	oack = make(map[string]string)
+
+	if v, ok := opts["blksize"]; ok {
+		if n, err := strconv.Atoi(v); err == nil {
+			if n < minBlockSize {
+				n = minBlockSize
+			}
+			if n > maxBlockSize {
+				n = maxBlockSize
+			}
+			blockSize = n
+			oack["blksize"] = strconv.Itoa(n)
+		}
+	}
+
+	if _, ok := opts["tsize"]; ok && haveTSize {
+		oack["tsize"] = strconv.FormatInt(tsize, 10)
+	}
+
+	if v, ok := opts["timeout"]; ok {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			sessionTimeout = time.Duration(n) * time.Second
+			oack["timeout"] = v
+		}
+	}
+
+	if v, ok := opts["windowsize"]; ok && maxWindowSize > 1 {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			if n > int(maxWindowSize) {
+				n = int(maxWindowSize)
+			}
+			windowSize = uint16(n)
+			oack["windowsize"] = strconv.Itoa(n)
+		}
+	}
+
+	if len(oack) == 0 {
+		return nil, blockSize, sessionTimeout, windowSize
+	}
+
+	return oack, blockSize, sessionTimeout, windowSize
+}
+
 type TFTPServer struct {
 	// Whether to accept WriteRequest or not
 	WriteAllowed bool
@@ -18,6 +81,66 @@ type TFTPServer struct {
 	Payload  []byte
 	Retries  uint8
 	Timeout  time.Duration
+	// MaxFileSize, if non-zero, rejects WRQ sessions whose negotiated or
+	// observed size exceeds this many bytes with ErrDiskFull.
+	MaxFileSize int64
+	// WindowSize is the largest RFC 7440 windowsize the server will
+	// negotiate with a client. Defaults to 1, i.e. plain RFC 1350
+	// lockstep, one DATA per ACK.
+	WindowSize uint16
+	// Filesystem resolves RRQ/WRQ filenames against a storage backend.
+	// When nil, the server falls back to serving Payload for every RRQ
+	// regardless of the requested filename, and writes land under
+	// WriteDir on the local disk.
+	Filesystem Filesystem
+	// Logger receives diagnostic messages the server would otherwise send
+	// to the standard library's default logger.
+	Logger Logger
+	// OnSessionStart, if set, is called when a RRQ/WRQ session begins.
+	// req is the ReadReq or WriteReq that started it.
+	OnSessionStart func(remote net.Addr, req interface{})
+	// OnSessionEnd, if set, is called when a session ends, reporting the
+	// number of payload bytes transferred and the error (if any) it
+	// ended with. A nil err means the transfer completed successfully.
+	OnSessionEnd func(remote net.Addr, bytes int64, err error)
+	// OnPacket, if set, is called for every DATA/ACK/OACK/ERR packet sent
+	// or received during a session.
+	OnPacket func(dir Direction, op OperationCode)
+}
+
+// openRead resolves filename for a RRQ, via Filesystem when configured or
+// the legacy single-blob Payload otherwise.
+func (s TFTPServer) openRead(filename string) (io.ReadSeekCloser, int64, error) {
+	if s.Filesystem != nil {
+		f, err := s.Filesystem.Open(filename)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		info, err := s.Filesystem.Stat(filename)
+		if err != nil {
+			_ = f.Close()
+			return nil, 0, err
+		}
+
+		return f, info.Size(), nil
+	}
+
+	if s.Payload == nil {
+		return nil, 0, errors.New("tftp: no payload configured")
+	}
+
+	return readSeekNopCloser{bytes.NewReader(s.Payload)}, int64(len(s.Payload)), nil
+}
+
+// createWrite resolves filename for a WRQ, via Filesystem when configured
+// or an OSFilesystem rooted at WriteDir otherwise.
+func (s TFTPServer) createWrite(filename string) (io.WriteCloser, error) {
+	if s.Filesystem != nil {
+		return s.Filesystem.Create(filename)
+	}
+
+	return OSFilesystem{Root: s.WriteDir}.Create(filename)
 }
 
 // Blocking function
@@ -38,10 +161,6 @@ func (s *TFTPServer) Serve(conn net.PacketConn) error {
 		return errors.New("Nil Connection")
 	}
 
-	if s.Payload == nil {
-		return errors.New("Payload is required")
-	}
-
 	if s.Retries == 0 {
 		s.Retries = 10
 	}
@@ -50,200 +169,476 @@ func (s *TFTPServer) Serve(conn net.PacketConn) error {
 		s.Timeout = 4 * time.Second
 	}
 
-	var rrq ReadReq
-	var wrq WriteReq
+	if s.WindowSize == 0 {
+		s.WindowSize = 1
+	}
+
 	for {
 		buf := make([]byte, DatagramSize)
-		_, addr, err := conn.ReadFrom(buf)
+		n, addr, err := conn.ReadFrom(buf)
 		if err != nil {
 			return err
 		}
+		packet := buf[:n]
 
-		err = rrq.UnmarshalBinary(buf)
-		if err == nil {
-			go s.handleRead(addr.String(), rrq)
+		// Parse into session-local values so each goroutine gets its own
+		// copy; nothing here is shared across concurrent clients.
+		var rrq ReadReq
+		if err := rrq.UnmarshalBinary(packet); err == nil {
+			go s.handleRead(addr, rrq)
 			continue
 		}
 
-		err = wrq.UnmarshalBinary(buf)
-		if err == nil {
+		var wrq WriteReq
+		if err := wrq.UnmarshalBinary(packet); err == nil {
 			if s.WriteAllowed == false {
 				data, _ := Err{Error: ErrIllegalOp, Message: "We don't accept write requests"}.MarshalBinary()
 				_, _ = conn.WriteTo(data, addr)
+				continue
 			}
-			go s.handleWrite(addr.String(), wrq)
+			go s.handleWrite(addr, wrq)
 			continue
 		}
 
-		log.Printf("[%s] bad request: %v", addr, err)
-		continue
+		s.logf("[%s] bad request: %v", addr, err)
+	}
+}
+
+// newSessionConn allocates a fresh ephemeral UDP socket (the server's TID
+// for this session, per RFC 1350 §4) and wraps it as a net.Conn bound to
+// client. Packets arriving from any other source address are answered with
+// ErrUnknownID and otherwise ignored.
+func newSessionConn(client net.Addr) (net.Conn, error) {
+	pc, err := net.ListenPacket("udp", ":0")
+	if err != nil {
+		return nil, err
+	}
+
+	return &sessionConn{pc: pc, remote: client}, nil
+}
+
+type sessionConn struct {
+	pc     net.PacketConn
+	remote net.Addr
+}
+
+func (c *sessionConn) Read(p []byte) (int, error) {
+	for {
+		n, addr, err := c.pc.ReadFrom(p)
+		if err != nil {
+			return n, err
+		}
+
+		if addr.String() == c.remote.String() {
+			return n, nil
+		}
+
+		// Wrong TID: reply with ErrUnknownID and keep waiting for the
+		// real client, per RFC 1350 §4.
+		data, merr := Err{Error: ErrUnknownID, Message: "unknown transfer ID"}.MarshalBinary()
+		if merr == nil {
+			_, _ = c.pc.WriteTo(data, addr)
+		}
 	}
 }
 
-func (s TFTPServer) handleRead(clientAddr string, rrq ReadReq) {
-	log.Printf("[%s] requested read file: %s", clientAddr, rrq.Filename)
+func (c *sessionConn) Write(p []byte) (int, error) {
+	return c.pc.WriteTo(p, c.remote)
+}
 
-	// Using random transfer identifier for each tftp session
-	conn, err := net.Dial("udp", clientAddr)
+func (c *sessionConn) Close() error {
+	return c.pc.Close()
+}
+
+func (c *sessionConn) LocalAddr() net.Addr  { return c.pc.LocalAddr() }
+func (c *sessionConn) RemoteAddr() net.Addr { return c.remote }
+
+func (c *sessionConn) SetDeadline(t time.Time) error      { return c.pc.SetDeadline(t) }
+func (c *sessionConn) SetReadDeadline(t time.Time) error  { return c.pc.SetReadDeadline(t) }
+func (c *sessionConn) SetWriteDeadline(t time.Time) error { return c.pc.SetWriteDeadline(t) }
+
+// readBlock marshals the DATA packet for the given 1-based block number by
+// seeking src to its offset. Blocks are addressable independent of each
+// other so a windowed sender can resend any subset without replaying a
+// stream.
+func readBlock(src io.ReadSeeker, block uint16, blockSize int) ([]byte, error) {
+	offset := int64(block-1) * int64(blockSize)
+	if _, err := src.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	d := Data{Block: block - 1, BlockSize: blockSize, Payload: io.LimitReader(src, int64(blockSize))}
+	return d.MarshalBinary()
+}
+
+// blockPayloadSize reports the number of actual payload bytes carried by
+// the given 1-based block number, accounting for the final block of a
+// transfer being shorter than blockSize (or empty).
+func blockPayloadSize(block, totalBlocks uint16, size int64, blockSize int) int64 {
+	if block < totalBlocks {
+		return int64(blockSize)
+	}
+	return size - int64(totalBlocks-1)*int64(blockSize)
+}
+
+func (s TFTPServer) handleRead(clientAddr net.Addr, rrq ReadReq) {
+	s.logf("[%s] requested read file: %s", clientAddr, rrq.Filename)
+	s.fireSessionStart(clientAddr, rrq)
+
+	var sent int64
+	var sessionErr error
+	defer func() { s.fireSessionEnd(clientAddr, sent, sessionErr) }()
+
+	// Fresh ephemeral socket: this session's TID, per RFC 1350 §4.
+	conn, err := newSessionConn(clientAddr)
 	if err != nil {
-		log.Printf("[%s] dial: %v", clientAddr, err)
+		s.logf("[%s] dial: %v", clientAddr, err)
+		sessionErr = err
 		return
 	}
 	defer func() { _ = conn.Close() }()
 
+	src, size, err := s.openRead(rrq.Filename)
+	if err != nil {
+		s.logf("[%s] open %s: %v", clientAddr, rrq.Filename, err)
+		data, _ := Err{Error: ErrNotFound, Message: err.Error()}.MarshalBinary()
+		_, _ = conn.Write(data)
+		s.firePacket(DirSent, OpErr)
+		sessionErr = err
+		return
+	}
+	defer func() { _ = src.Close() }()
+
+	oack, blockSize, timeout, windowSize := negotiateOptions(rrq.Options, s.Timeout, size, true, s.WindowSize)
+
 	var (
-		ackPkt  Ack
-		errPkt  Err
-		dataPkt = Data{Payload: bytes.NewReader(s.Payload)}
-		buf     = make([]byte, DatagramSize)
+		ackPkt Ack
+		errPkt Err
+		buf    = make([]byte, maxBlockSize+4)
 	)
 
-NEXTPACKET:
-	for n := DatagramSize; n == DatagramSize; {
-		data, err := dataPkt.MarshalBinary()
-		if err != nil {
-			log.Printf("[%s] preparing data packet: %v", clientAddr, err)
+	if oack != nil {
+		if err := s.sendOAck(conn, clientAddr, oack, timeout, buf); err != nil {
+			s.logf("[%s] OACK: %v", clientAddr, err)
+			sessionErr = err
 			return
 		}
-	RETRY:
-		for i := s.Retries; i > 0; i-- {
-			n, err = conn.Write(data)
+	}
+
+	// Data blocks are numbered 1..totalBlocks; there's always a final
+	// (possibly empty) short block, even when the file size is an exact
+	// multiple of blockSize.
+	totalBlocks := uint16(size/int64(blockSize) + 1)
+	var nextBlock uint16 = 1
+
+NEXTWINDOW:
+	for nextBlock <= totalBlocks {
+		windowEnd := nextBlock + windowSize - 1
+		if windowEnd > totalBlocks {
+			windowEnd = totalBlocks
+		}
+
+		packets := make([][]byte, 0, windowEnd-nextBlock+1)
+		for b := nextBlock; b <= windowEnd; b++ {
+			pkt, err := readBlock(src, b, blockSize)
 			if err != nil {
-				log.Printf("[%s] write: %v", clientAddr, err)
+				s.logf("[%s] preparing data packet: %v", clientAddr, err)
+				sessionErr = err
 				return
 			}
-			// wait for client's Ack packet
-			_ = conn.SetReadDeadline(time.Now().Add(s.Timeout))
+			packets = append(packets, pkt)
+		}
+
+	RETRY:
+		for i := s.Retries; i > 0; i-- {
+			for _, pkt := range packets {
+				if _, err := conn.Write(pkt); err != nil {
+					s.logf("[%s] write: %v", clientAddr, err)
+					sessionErr = err
+					return
+				}
+				s.firePacket(DirSent, OpData)
+			}
+
+			// wait for the client's single cumulative ACK for the window
+			_ = conn.SetReadDeadline(time.Now().Add(timeout))
 
-			_, err = conn.Read(buf)
+			n, err := conn.Read(buf)
 			if err != nil {
 				if nErr, ok := err.(net.Error); ok && nErr.Timeout() {
+					// retransmit the entire un-acked window
 					continue RETRY
 				}
 
-				log.Printf("[%s] waiting for ACK: %v", clientAddr, err)
+				s.logf("[%s] waiting for ACK: %v", clientAddr, err)
+				sessionErr = err
 				return
 			}
 
 			switch {
-			case ackPkt.UnmarshalBinary(buf) == nil:
-				if uint16(ackPkt.Block) == dataPkt.Block {
-					// received ACK; send next data packet
-					continue NEXTPACKET
+			case ackPkt.UnmarshalBinary(buf[:n]) == nil:
+				s.firePacket(DirReceived, OpAck)
+				switch {
+				case ackPkt.Block == windowEnd:
+					// whole window acknowledged; slide on
+					for b := nextBlock; b <= windowEnd; b++ {
+						sent += blockPayloadSize(b, totalBlocks, size, blockSize)
+					}
+					nextBlock = windowEnd + 1
+					continue NEXTWINDOW
+				case ackPkt.Block >= nextBlock-1 && ackPkt.Block < windowEnd:
+					// partial ACK: blocks after K were lost, resend from K+1
+					for b := nextBlock; b <= ackPkt.Block; b++ {
+						sent += blockPayloadSize(b, totalBlocks, size, blockSize)
+					}
+					nextBlock = ackPkt.Block + 1
+					continue NEXTWINDOW
 				}
+				// stale/duplicate ACK outside the current window; ignore and retry
 
-			case errPkt.UnmarshalBinary(buf) == nil:
-				log.Printf("[%s] received error: %v",
+			case errPkt.UnmarshalBinary(buf[:n]) == nil:
+				s.firePacket(DirReceived, OpErr)
+				s.logf("[%s] received error: %v",
 					clientAddr, errPkt.Message)
+				sessionErr = errors.New(errPkt.Message)
 				return
 			default:
-				log.Printf("[%s] bad packet: %v", clientAddr, buf)
+				s.logf("[%s] bad packet: %v", clientAddr, buf[:n])
 			}
-
 		}
-		log.Printf("[%s] exhausted retries", clientAddr)
+		s.logf("[%s] exhausted retries", clientAddr)
+		sessionErr = errors.New("tftp: exhausted retries")
 		return
 	}
-	log.Printf("[%s] send %d blocks", clientAddr, dataPkt.Block)
+	s.logf("[%s] send %d blocks", clientAddr, totalBlocks)
 }
 
-func (s TFTPServer) handleWrite(clientAddr string, wrq WriteReq) {
-	log.Printf("[%s] Requested write file: %s", clientAddr, wrq.Filename)
+// sendOAck sends the OACK packet negotiating opts and waits for the
+// client's ACK of block 0 before the data phase begins, retrying on
+// timeout like the rest of the session.
+func (s TFTPServer) sendOAck(conn net.Conn, clientAddr net.Addr, opts map[string]string, timeout time.Duration, buf []byte) error {
+	data, err := OAck{Options: opts}.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	var ackPkt Ack
+	var errPkt Err
 
-	// Using random transfer identifier for each tftp session
-	conn, err := net.Dial("udp", clientAddr)
+	for i := s.Retries; i > 0; i-- {
+		if _, err := conn.Write(data); err != nil {
+			return err
+		}
+		s.firePacket(DirSent, OpOAck)
+
+		_ = conn.SetReadDeadline(time.Now().Add(timeout))
+
+		n, err := conn.Read(buf)
+		if err != nil {
+			if nErr, ok := err.(net.Error); ok && nErr.Timeout() {
+				continue
+			}
+			return err
+		}
+
+		if ackPkt.UnmarshalBinary(buf[:n]) == nil && ackPkt.Block == 0 {
+			s.firePacket(DirReceived, OpAck)
+			return nil
+		}
+
+		if errPkt.UnmarshalBinary(buf[:n]) == nil {
+			s.firePacket(DirReceived, OpErr)
+			return errors.New("client rejected OACK: " + errPkt.Message)
+		}
+	}
+
+	return errors.New("exhausted retries waiting for OACK ack")
+}
+
+func (s TFTPServer) handleWrite(clientAddr net.Addr, wrq WriteReq) {
+	s.logf("[%s] Requested write file: %s", clientAddr, wrq.Filename)
+	s.fireSessionStart(clientAddr, wrq)
+
+	var written int64
+	var sessionErr error
+	defer func() { s.fireSessionEnd(clientAddr, written, sessionErr) }()
+
+	// Fresh ephemeral socket: this session's TID, per RFC 1350 §4.
+	conn, err := newSessionConn(clientAddr)
 
 	if err != nil {
-		log.Printf("[%s] dial: %v", clientAddr, err)
+		s.logf("[%s] dial: %v", clientAddr, err)
+		sessionErr = err
 		return
 	}
 	defer conn.Close()
 
+	var expectedSize int64
+	var haveExpectedSize bool
+	if v, ok := wrq.Options["tsize"]; ok {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+			expectedSize = n
+			haveExpectedSize = true
+		}
+	}
+
+	oack, blockSize, timeout, windowSize := negotiateOptions(wrq.Options, s.Timeout, expectedSize, haveExpectedSize, s.WindowSize)
+
+	if s.MaxFileSize > 0 && haveExpectedSize && expectedSize > s.MaxFileSize {
+		data, _ := Err{Error: ErrDiskFull, Message: "file too large"}.MarshalBinary()
+		_, _ = conn.Write(data)
+		s.firePacket(DirSent, OpErr)
+		s.logf("[%s] rejected write: %d exceeds MaxFileSize %d", clientAddr, expectedSize, s.MaxFileSize)
+		sessionErr = errors.New("tftp: file too large")
+		return
+	}
+
 	var (
 		ackPkt  Ack
 		errPkt  Err
-		dataPkt Data
-		buf     = make([]byte, DatagramSize)
+		dataPkt = Data{BlockSize: blockSize}
+		buf     = make([]byte, maxBlockSize+4)
 	)
 
-	// Initial Ack packet to WRQ
-	data, err := ackPkt.MarshalBinary()
-	if err != nil {
-		log.Printf("Can not marshal the ack packet: %s", err)
-		return
-	}
+	if oack != nil {
+		if err := s.sendOAck(conn, clientAddr, oack, timeout, buf); err != nil {
+			s.logf("[%s] OACK: %v", clientAddr, err)
+			sessionErr = err
+			return
+		}
+	} else {
+		// Initial Ack packet to WRQ
+		data, err := ackPkt.MarshalBinary()
+		if err != nil {
+			s.logf("Can not marshal the ack packet: %s", err)
+			sessionErr = err
+			return
+		}
 
-	_, err = conn.Write(data)
+		_, err = conn.Write(data)
 
-	if err != nil {
-		log.Printf("[%s] ack: %v", clientAddr, err)
-		return
+		if err != nil {
+			s.logf("[%s] ack: %v", clientAddr, err)
+			sessionErr = err
+			return
+		}
+		s.firePacket(DirSent, OpAck)
 	}
 
-	file, err := os.Create(wrq.Filename)
+	file, err := s.createWrite(wrq.Filename)
 	if err != nil {
-		log.Printf("[%s] CreateFile: %v", clientAddr, err)
+		s.logf("[%s] CreateFile: %v", clientAddr, err)
+		sessionErr = err
 		return
 	}
 
 	defer func() {
-		err = file.Close()
-		if err != nil {
-			log.Printf("Can not close the file: %s", err)
+		if cerr := file.Close(); cerr != nil {
+			s.logf("Can not close the file: %s", cerr)
 		}
 	}()
 
-	// Recieve datagrams until the last one comes. last datagram is always less than 516 Bytes.
-	for n := DatagramSize; n == DatagramSize; {
+	var inWindow uint16
+	var expectedBlock uint16 = 1
+	var n int
+	done := false
+
+	// Recieve datagrams until the last one comes.
+	for !done {
 		n, err = conn.Read(buf)
-		log.Println(n)
 		if err != nil {
-			log.Printf("Error when reading from connection: %s", err)
+			s.logf("Error when reading from connection: %s", err)
+			sessionErr = err
 			return
 		}
 
-		err = errPkt.UnmarshalBinary(buf)
+		err = errPkt.UnmarshalBinary(buf[:n])
 		if err == nil {
-			log.Printf("[%s] received error: %v",
+			s.firePacket(DirReceived, OpErr)
+			s.logf("[%s] received error: %v",
 				clientAddr, errPkt.Message)
+			sessionErr = errors.New(errPkt.Message)
 			return
 		}
 
-		err = dataPkt.UnmarshalBinary(buf)
+		err = dataPkt.UnmarshalBinary(buf[:n])
 
 		if err != nil {
-			log.Println(err)
+			s.logf("[%s] bad DATA packet: %v", clientAddr, err)
+			sessionErr = err
 			return
 		}
+		s.firePacket(DirReceived, OpData)
 
 		data, err := io.ReadAll(dataPkt.Payload)
 
 		if err != nil {
-			log.Fatalf("Error reading from reader: %v", err)
+			s.logf("[%s] reading data payload: %v", clientAddr, err)
+			sessionErr = err
 			return
 		}
 
-		_, err = file.Write(data[:n-4])
-		if err != nil {
-			log.Printf("can't write the buffer into disk: %s", err)
-			return
+		// A retransmitted DATA packet (e.g. the whole window resent after
+		// the client's ACK was lost) repeats a block already on disk, or
+		// arrives out of order while an earlier block is still missing;
+		// drop it and wait for the block we actually need next. done only
+		// becomes true once the block we were expecting turns out to be
+		// short - never on an out-of-order packet that merely happens to
+		// be short.
+		if dataPkt.Block == expectedBlock {
+			written += int64(len(data))
+			if s.MaxFileSize > 0 && written > s.MaxFileSize {
+				errData, _ := Err{Error: ErrDiskFull, Message: "file too large"}.MarshalBinary()
+				_, _ = conn.Write(errData)
+				s.firePacket(DirSent, OpErr)
+				s.logf("[%s] write exceeded MaxFileSize %d", clientAddr, s.MaxFileSize)
+				sessionErr = errors.New("tftp: file too large")
+				return
+			}
+
+			_, err = file.Write(data)
+			if err != nil {
+				s.logf("can't write the buffer into disk: %s", err)
+				sessionErr = err
+				return
+			}
+			expectedBlock++
+			done = len(data) < blockSize
+		}
+
+		// The cumulative ACK reports the highest block actually written in
+		// order, not whichever packet happened to complete the window
+		// count - otherwise a lost block can make the ACK coincidentally
+		// equal the window end and the sender wrongly believes everything
+		// landed.
+		ackPkt.Block = expectedBlock - 1
+		inWindow++
+
+		// Only ACK once windowSize DATA packets have been accumulated, or
+		// once the final short packet that ends the transfer has actually
+		// been written.
+		if !done && inWindow < windowSize {
+			continue
 		}
+		inWindow = 0
 
-		ackPkt.Block = dataPkt.Block
-		// Acknowledge the data packet
+		// Acknowledge the window
 		data, err = ackPkt.MarshalBinary()
 		if err != nil {
-			log.Printf("Can not marshal the ack packet: %s", err)
+			s.logf("Can not marshal the ack packet: %s", err)
+			sessionErr = err
 			return
 		}
 
 		_, err = conn.Write(data)
 
 		if err != nil {
-			log.Printf("[%s] ack: %v", clientAddr, err)
+			s.logf("[%s] ack: %v", clientAddr, err)
+			sessionErr = err
 			return
 		}
+		s.firePacket(DirSent, OpAck)
 	}
 	// Out of the loop means we recieved every legit datagram for this connection.
-	log.Printf("[%s] Recieved %d blocks of data. Written to the file %s", clientAddr, ackPkt.Block, file.Name())
+	s.logf("[%s] Recieved %d blocks of data. Written to the file %s", clientAddr, ackPkt.Block, wrq.Filename)
 }
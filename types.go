@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"sort"
 	"strings"
 )
 
@@ -25,6 +26,7 @@ const (
 	OpData
 	OpAck
 	OpErr
+	OpOAck
 )
 
 type ErrCode uint16
@@ -49,9 +51,94 @@ const (
 	------------------------------------------------
 */
 
+// parseOptions reads the trailing "name\0value\0" pairs that RFC 2347 option
+// extension appends after the Mode field. A malformed trailing pair (a name
+// with no matching value) is silently dropped, per RFC 2347 § the server
+// must ignore options it does not understand or cannot parse.
+func parseOptions(b *bytes.Buffer) map[string]string {
+	if b.Len() == 0 {
+		return nil
+	}
+
+	opts := make(map[string]string)
+
+	for b.Len() > 0 {
+		name, err := b.ReadString(0)
+		if err != nil {
+			break
+		}
+		name = strings.ToLower(strings.TrimRight(name, "\x00"))
+
+		value, err := b.ReadString(0)
+		if err != nil {
+			break
+		}
+		value = strings.TrimRight(value, "\x00")
+
+		if name == "" {
+			continue
+		}
+
+		opts[name] = value
+	}
+
+	if len(opts) == 0 {
+		return nil
+	}
+
+	return opts
+}
+
 type WriteReq struct {
 	Filename string
 	Mode     string
+	// Options holds the RFC 2347 option extension pairs ("blksize",
+	// "tsize", "timeout", ...) requested by the client, lower-cased.
+	Options map[string]string
+}
+
+func (w WriteReq) MarshalBinary() ([]byte, error) {
+	mode := "octet"
+
+	if w.Mode != "" {
+		mode = w.Mode
+	}
+
+	cap := 2 + len(w.Filename) + 1 + len(mode) + 1
+
+	buffer := new(bytes.Buffer)
+	buffer.Grow(cap)
+
+	err := binary.Write(buffer, binary.BigEndian, OpWRQ)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = buffer.WriteString(w.Filename)
+	if err != nil {
+		return nil, err
+	}
+
+	err = buffer.WriteByte(0)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = buffer.WriteString(mode)
+	if err != nil {
+		return nil, err
+	}
+
+	err = buffer.WriteByte(0)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := writeOptions(buffer, w.Options); err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
 }
 
 func (w *WriteReq) UnmarshalBinary(p []byte) error {
@@ -80,12 +167,17 @@ func (w *WriteReq) UnmarshalBinary(p []byte) error {
 		return errors.New("No. We only accept data in octet mode.")
 	}
 
+	w.Options = parseOptions(b)
+
 	return nil
 }
 
 type ReadReq struct {
 	Filename string
 	Mode     string
+	// Options holds the RFC 2347 option extension pairs ("blksize",
+	// "tsize", "timeout", ...) requested by the client, lower-cased.
+	Options map[string]string
 }
 
 func (q ReadReq) MarshalBinary() ([]byte, error) {
@@ -127,10 +219,45 @@ func (q ReadReq) MarshalBinary() ([]byte, error) {
 		return nil, err
 	}
 
+	if err := writeOptions(buffer, q.Options); err != nil {
+		return nil, err
+	}
+
 	return buffer.Bytes(), nil
 
 }
 
+// writeOptions appends the RFC 2347 "name\0value\0" pairs for opts, in
+// sorted key order so the wire encoding is deterministic.
+func writeOptions(buffer *bytes.Buffer, opts map[string]string) error {
+	if len(opts) == 0 {
+		return nil
+	}
+
+	names := make([]string, 0, len(opts))
+	for name := range opts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if _, err := buffer.WriteString(name); err != nil {
+			return err
+		}
+		if err := buffer.WriteByte(0); err != nil {
+			return err
+		}
+		if _, err := buffer.WriteString(opts[name]); err != nil {
+			return err
+		}
+		if err := buffer.WriteByte(0); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 func (q *ReadReq) UnmarshalBinary(p []byte) error {
 	r := bytes.NewBuffer(p)
 
@@ -174,6 +301,8 @@ func (q *ReadReq) UnmarshalBinary(p []byte) error {
 		return errors.New("Only binary transfer supported. please use octet mode")
 	}
 
+	q.Options = parseOptions(r)
+
 	return nil
 }
 
@@ -181,11 +310,19 @@ func (q *ReadReq) UnmarshalBinary(p []byte) error {
 type Data struct {
 	Block   uint16
 	Payload io.Reader
+	// BlockSize is the negotiated per-session payload size (RFC 2348).
+	// Zero means "use the RFC 1350 default", BlockSize constant.
+	BlockSize int
 }
 
 func (d *Data) MarshalBinary() ([]byte, error) {
+	blockSize := d.BlockSize
+	if blockSize == 0 {
+		blockSize = BlockSize
+	}
+
 	b := new(bytes.Buffer)
-	b.Grow(DatagramSize)
+	b.Grow(blockSize + 4)
 
 	d.Block++ // block numbers increment from 1
 
@@ -199,8 +336,8 @@ func (d *Data) MarshalBinary() ([]byte, error) {
 		return nil, err
 	}
 
-	// write up to BlockSize worth of bytes
-	_, err = io.CopyN(b, d.Payload, BlockSize)
+	// write up to blockSize worth of bytes
+	_, err = io.CopyN(b, d.Payload, int64(blockSize))
 	if err != nil && err != io.EOF {
 		return nil, err
 	}
@@ -209,7 +346,12 @@ func (d *Data) MarshalBinary() ([]byte, error) {
 }
 
 func (d *Data) UnmarshalBinary(p []byte) error {
-	if l := len(p); l < 4 || l > DatagramSize {
+	blockSize := d.BlockSize
+	if blockSize == 0 {
+		blockSize = BlockSize
+	}
+
+	if l := len(p); l < 4 || l > blockSize+4 {
 		return errors.New("invalid DATA")
 	}
 
@@ -272,6 +414,54 @@ func (a *Ack) UnmarshalBinary(p []byte) error {
 	return binary.Read(r, binary.BigEndian, &a.Block)
 }
 
+/*
+	OACK packet (RFC 2347)
+
+	2 bytes     string    1 byte   string    1 byte     ...
+	--------------------------------------------------------
+	| Opcode |  opt1  |   0  |  value1  |   0  |   ...       |
+	--------------------------------------------------------
+*/
+
+type OAck struct {
+	Options map[string]string
+}
+
+func (o OAck) MarshalBinary() ([]byte, error) {
+	buffer := new(bytes.Buffer)
+	buffer.Grow(2)
+
+	err := binary.Write(buffer, binary.BigEndian, OpOAck)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := writeOptions(buffer, o.Options); err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+func (o *OAck) UnmarshalBinary(p []byte) error {
+	b := bytes.NewBuffer(p)
+
+	var code OperationCode
+
+	err := binary.Read(b, binary.BigEndian, &code)
+	if err != nil {
+		return err
+	}
+
+	if code != OpOAck {
+		return errors.New("Invalid OACK: " + fmt.Sprint(code))
+	}
+
+	o.Options = parseOptions(b)
+
+	return nil
+}
+
 type Err struct {
 	Error ErrCode
 	// intended for human consumption
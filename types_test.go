@@ -0,0 +1,45 @@
+package tftp
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestParseOptionsMixedCase(t *testing.T) {
+	var b bytes.Buffer
+	b.WriteString("BlkSize\x00516\x00TSize\x000\x00")
+
+	opts := parseOptions(&b)
+
+	want := map[string]string{"blksize": "516", "tsize": "0"}
+	if len(opts) != len(want) {
+		t.Fatalf("parseOptions() = %v, want %v", opts, want)
+	}
+	for name, value := range want {
+		if opts[name] != value {
+			t.Errorf("opts[%q] = %q, want %q", name, opts[name], value)
+		}
+	}
+}
+
+func TestParseOptionsMalformedTrailingBytes(t *testing.T) {
+	// "timeout" has no value terminator; it should be dropped rather than
+	// returned half-parsed or causing the already-parsed options to be lost.
+	var b bytes.Buffer
+	b.WriteString("blksize\x00516\x00timeout")
+
+	opts := parseOptions(&b)
+
+	if opts["blksize"] != "516" {
+		t.Errorf("opts[\"blksize\"] = %q, want %q", opts["blksize"], "516")
+	}
+	if _, ok := opts["timeout"]; ok {
+		t.Errorf("malformed trailing option should have been dropped, got %v", opts)
+	}
+}
+
+func TestParseOptionsEmpty(t *testing.T) {
+	if opts := parseOptions(&bytes.Buffer{}); opts != nil {
+		t.Errorf("parseOptions(empty) = %v, want nil", opts)
+	}
+}
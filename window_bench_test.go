@@ -0,0 +1,117 @@
+package tftp
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+// benchmarkPayload is shared across the window-size sub-benchmarks so the
+// compared numbers reflect transport overhead, not payload generation.
+var benchmarkPayload = bytes.Repeat([]byte("x"), 256*1024)
+
+// windowedGetLossScenario starts a TFTPServer serving payload with a single
+// window large enough to cover the whole transfer, behind a lossyRelay that
+// drops DATA block 2 according to drop, then runs a Get against it.
+func windowedGetLossScenario(t *testing.T, payload []byte, drop map[uint16]int) error {
+	t.Helper()
+
+	windowSize := uint16(len(payload)/int(BlockSize) + 2)
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	server := TFTPServer{Payload: payload, WindowSize: windowSize, Timeout: 200 * time.Millisecond}
+	go func() { _ = server.Serve(conn) }()
+
+	relay, err := newLossyRelay(conn.LocalAddr().(*net.UDPAddr), drop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer relay.close()
+	go relay.run()
+
+	client := Client{WindowSize: windowSize, Timeout: 200 * time.Millisecond, Retries: 5}
+
+	var out bytes.Buffer
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err = client.Get(ctx, relay.addr(), "any", &out)
+	if err == nil && !bytes.Equal(out.Bytes(), payload) {
+		t.Fatalf("Get returned nil error but downloaded content did not match (got %d bytes, want %d)", out.Len(), len(payload))
+	}
+	return err
+}
+
+// TestWindowedGetRecoversFromTransientLoss downloads a single-window file
+// with one DATA block dropped exactly once, and checks the result
+// byte-for-byte against the original payload. A cumulative ACK that reports
+// the wrong block (instead of the highest block actually written) can
+// desync the transfer so it never converges.
+func TestWindowedGetRecoversFromTransientLoss(t *testing.T) {
+	payload := bytes.Repeat([]byte("y"), 6*int(BlockSize))
+
+	if err := windowedGetLossScenario(t, payload, map[uint16]int{2: 1}); err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+}
+
+// TestWindowedGetFailsClosedOnPersistentLoss permanently drops one DATA
+// block (simulating a link that never delivers it) and requires Get to
+// fail rather than silently return a truncated download as a success. The
+// block dropped is not the final (short) one, so a buggy cumulative ACK
+// that trusts whichever packet completed the window count - instead of the
+// highest block actually written in order - would see the final short
+// packet, conclude the transfer is done, and return a nil error over a
+// truncated file.
+func TestWindowedGetFailsClosedOnPersistentLoss(t *testing.T) {
+	payload := bytes.Repeat([]byte("y"), 6*int(BlockSize))
+
+	err := windowedGetLossScenario(t, payload, map[uint16]int{2: 1 << 30})
+	if err == nil {
+		t.Fatal("Get returned nil error despite block 2 never being delivered")
+	}
+}
+
+// BenchmarkThroughputWindowSize compares RFC 7440 windowed transfer
+// throughput at window sizes 1 (plain RFC 1350 lockstep), 4, and 16 over a
+// loopback net.PacketConn.
+func BenchmarkThroughputWindowSize(b *testing.B) {
+	for _, windowSize := range []uint16{1, 4, 16} {
+		windowSize := windowSize
+
+		b.Run(fmt.Sprintf("window=%d", windowSize), func(b *testing.B) {
+			conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer conn.Close()
+
+			server := TFTPServer{Payload: benchmarkPayload, WindowSize: windowSize}
+			go func() { _ = server.Serve(conn) }()
+
+			addr := conn.LocalAddr().String()
+			client := Client{WindowSize: windowSize}
+
+			b.ResetTimer()
+			b.SetBytes(int64(len(benchmarkPayload)))
+
+			for i := 0; i < b.N; i++ {
+				var out bytes.Buffer
+				ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+				err := client.Get(ctx, addr, "any", &out)
+				cancel()
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}